@@ -6,8 +6,8 @@ use crate::utils::ffmpeg::FFmpegPreset;
 use crate::utils::filter::{Filter, FilterMediaScope};
 use crate::utils::format::{Format, SingleFormat};
 use crate::utils::locale::{all_locale_in_locales, resolve_locales, LanguageTagging};
-use crate::utils::log::progress;
-use crate::utils::os::{free_file, has_ffmpeg, is_special_file};
+use crate::utils::log::{progress, tab_info};
+use crate::utils::os::{check_ffmpeg, ffmpeg_path, free_file, is_special_file};
 use crate::utils::parse::parse_url;
 use crate::utils::video::stream_data_from_stream;
 use crate::Execute;
@@ -16,7 +16,8 @@ use anyhow::Result;
 use chrono::Duration;
 use crunchyroll_rs::media::{Resolution, Subtitle};
 use crunchyroll_rs::Locale;
-use log::{debug, warn};
+use indicatif::HumanBytes;
+use log::{debug, info, warn};
 use regex::Regex;
 use std::fmt::{Display, Formatter};
 use std::iter::zip;
@@ -83,7 +84,8 @@ pub struct Archive {
     Can either be specified via the pixels (e.g. 1920x1080), the abbreviation for pixels (e.g. 1080p) or 'common-use' words (e.g. best). \
     Specifying the exact pixels is not recommended, use one of the other options instead. \
     Crunchyroll let you choose the quality with pixel abbreviation on their clients, so you might be already familiar with the available options. \
-    The available common-use words are 'best' (choose the best resolution available) and 'worst' (worst resolution available)")]
+    The available common-use words are 'best' (choose the best resolution available) and 'worst' (worst resolution available). \
+    If the specified resolution is not available, the closest lower resolution is used (or the lowest available one, if there is no lower resolution)")]
     #[arg(short, long, default_value = "best")]
     #[arg(value_parser = crate::utils::clap::clap_parse_resolution)]
     pub(crate) resolution: Resolution,
@@ -165,6 +167,11 @@ pub struct Archive {
     )]
     #[arg(long, default_value_t = false)]
     pub(crate) include_chapters: bool,
+    #[arg(
+        help = "Includes metadata like the title, series name and release date of the episode in the output file"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) include_metadata: bool,
 
     #[arg(help = "Omit closed caption subtitles in the downloaded file")]
     #[arg(long, default_value_t = false)]
@@ -191,9 +198,32 @@ pub struct Archive {
     #[arg(short, long, default_value_t = false)]
     pub(crate) yes: bool,
 
-    #[arg(help = "The number of threads used to download")]
+    #[arg(help = "Only show what would be downloaded without downloading anything")]
+    #[arg(
+        long_help = "Only show what would be downloaded without downloading anything. \
+    Besides the usual information, the number of segments and the estimated size of every video is shown"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) dry_run: bool,
+
+    #[arg(help = "The number of threads used to download. 0 uses the number of cpu threads")]
     #[arg(short, long, default_value_t = num_cpus::get())]
     pub(crate) threads: usize,
+    #[arg(help = "The number of retries if the download of a video or audio segment fails")]
+    #[arg(long, default_value_t = 5)]
+    pub(crate) retries: usize,
+    #[arg(
+        help = "The time in seconds to wait before a failed video or audio segment is downloaded again. Multiplied by the number of the retry"
+    )]
+    #[arg(long, default_value_t = 1)]
+    #[arg(value_parser = clap::value_parser!(u64).range(..=3600))]
+    pub(crate) retry_backoff: u64,
+    #[arg(
+        help = "The time in seconds after which the download of a video or audio segment is aborted and retried"
+    )]
+    #[arg(long, default_value_t = 60)]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    pub(crate) segment_timeout: u64,
 
     #[arg(help = "Crunchyroll series url(s)")]
     #[arg(required = true)]
@@ -202,9 +232,8 @@ pub struct Archive {
 
 impl Execute for Archive {
     fn pre_check(&mut self) -> Result<()> {
-        if !has_ffmpeg() {
-            bail!("FFmpeg is needed to run this command")
-        } else if PathBuf::from(&self.output)
+        check_ffmpeg()?;
+        if PathBuf::from(&self.output)
             .extension()
             .unwrap_or_default()
             .to_string_lossy()
@@ -360,6 +389,9 @@ impl Execute for Archive {
                         _ => None,
                     })
                     .threads(self.threads)
+                    .segment_retries(self.retries)
+                    .segment_retry_backoff(std::time::Duration::from_secs(self.retry_backoff))
+                    .segment_timeout(std::time::Duration::from_secs(self.segment_timeout))
                     .audio_locale_output_map(
                         zip(self.audio.clone(), self.output_audio_locales.clone()).collect(),
                     )
@@ -467,6 +499,16 @@ impl Execute for Archive {
 
                 format.visual_output(&path);
 
+                if self.dry_run {
+                    let (segments, size) = downloader.estimate();
+                    tab_info!(
+                        "Estimated size: {} ({} segments)",
+                        HumanBytes(size),
+                        segments
+                    );
+                    continue;
+                }
+
                 downloader.download(&path).await?
             }
         }
@@ -573,7 +615,14 @@ async fn get_format(
                     video: (video, single_format.audio.clone()),
                     audios: vec![(audio, single_format.audio.clone())],
                     subtitles,
-                    metadata: DownloadFormatMetadata { skip_events: None },
+                    metadata: DownloadFormatMetadata {
+                        skip_events: None,
+                        tags: if archive.include_metadata {
+                            single_format.metadata_tags()
+                        } else {
+                            vec![]
+                        },
+                    },
                 })
             }
         }
@@ -598,6 +647,11 @@ async fn get_format(
                 } else {
                     None
                 },
+                tags: if archive.include_metadata {
+                    format_pairs.first().unwrap().0.metadata_tags()
+                } else {
+                    vec![]
+                },
             },
         }),
         MergeBehavior::Auto | MergeBehavior::Sync => {
@@ -639,6 +693,11 @@ async fn get_format(
                                     } else {
                                         None
                                     },
+                                    tags: if archive.include_metadata {
+                                        single_format.metadata_tags()
+                                    } else {
+                                        vec![]
+                                    },
                                 },
                             },
                         ));
@@ -663,7 +722,7 @@ fn get_video_streams(path: &Path) -> Result<Option<(Vec<Locale>, Vec<Locale>)>>
         Regex::new(r"(?m)Stream\s#\d+:\d+\((?P<language>.+)\):\s(?P<type>(Audio|Subtitle))")
             .unwrap();
 
-    let ffmpeg = Command::new("ffmpeg")
+    let ffmpeg = Command::new(ffmpeg_path())
         .stdout(Stdio::null())
         .stderr(Stdio::piped())
         .arg("-hide_banner")
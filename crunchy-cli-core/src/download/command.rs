@@ -4,8 +4,8 @@ use crate::utils::ffmpeg::{FFmpegPreset, SOFTSUB_CONTAINERS};
 use crate::utils::filter::{Filter, FilterMediaScope};
 use crate::utils::format::{Format, SingleFormat};
 use crate::utils::locale::{resolve_locales, LanguageTagging};
-use crate::utils::log::progress;
-use crate::utils::os::{free_file, has_ffmpeg, is_special_file};
+use crate::utils::log::{progress, tab_info};
+use crate::utils::os::{check_ffmpeg, free_file, is_special_file};
 use crate::utils::parse::parse_url;
 use crate::utils::video::stream_data_from_stream;
 use crate::Execute;
@@ -13,7 +13,8 @@ use anyhow::bail;
 use anyhow::Result;
 use crunchyroll_rs::media::Resolution;
 use crunchyroll_rs::Locale;
-use log::{debug, error, warn};
+use indicatif::HumanBytes;
+use log::{debug, error, info, warn};
 use std::collections::HashMap;
 use std::path::Path;
 
@@ -75,7 +76,8 @@ pub struct Download {
     Can either be specified via the pixels (e.g. 1920x1080), the abbreviation for pixels (e.g. 1080p) or 'common-use' words (e.g. best). \
     Specifying the exact pixels is not recommended, use one of the other options instead. \
     Crunchyroll let you choose the quality with pixel abbreviation on their clients, so you might be already familiar with the available options. \
-    The available common-use words are 'best' (choose the best resolution available) and 'worst' (worst resolution available)")]
+    The available common-use words are 'best' (choose the best resolution available) and 'worst' (worst resolution available). \
+    If the specified resolution is not available, the closest lower resolution is used (or the lowest available one, if there is no lower resolution)")]
     #[arg(short, long, default_value = "best")]
     #[arg(value_parser = crate::utils::clap::clap_parse_resolution)]
     pub(crate) resolution: Resolution,
@@ -125,18 +127,46 @@ pub struct Download {
     Also chapters aren't always available, so in this case, just a big 'Episode' chapter from start to end will be created")]
     #[arg(long, default_value_t = false)]
     pub(crate) include_chapters: bool,
+    #[arg(
+        help = "Includes metadata like the title, series name and release date of the episode in the output file"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) include_metadata: bool,
 
     #[arg(help = "Skip any interactive input")]
     #[arg(short, long, default_value_t = false)]
     pub(crate) yes: bool,
 
+    #[arg(help = "Only show what would be downloaded without downloading anything")]
+    #[arg(
+        long_help = "Only show what would be downloaded without downloading anything. \
+    Besides the usual information, the number of segments and the estimated size of every video is shown"
+    )]
+    #[arg(long, default_value_t = false)]
+    pub(crate) dry_run: bool,
+
     #[arg(help = "Force subtitles to be always burnt-in")]
     #[arg(long, default_value_t = false)]
     pub(crate) force_hardsub: bool,
 
-    #[arg(help = "The number of threads used to download")]
+    #[arg(help = "The number of threads used to download. 0 uses the number of cpu threads")]
     #[arg(short, long, default_value_t = num_cpus::get())]
     pub(crate) threads: usize,
+    #[arg(help = "The number of retries if the download of a video or audio segment fails")]
+    #[arg(long, default_value_t = 5)]
+    pub(crate) retries: usize,
+    #[arg(
+        help = "The time in seconds to wait before a failed video or audio segment is downloaded again. Multiplied by the number of the retry"
+    )]
+    #[arg(long, default_value_t = 1)]
+    #[arg(value_parser = clap::value_parser!(u64).range(..=3600))]
+    pub(crate) retry_backoff: u64,
+    #[arg(
+        help = "The time in seconds after which the download of a video or audio segment is aborted and retried"
+    )]
+    #[arg(long, default_value_t = 60)]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    pub(crate) segment_timeout: u64,
 
     #[arg(help = "Url(s) to Crunchyroll episodes or series")]
     #[arg(required = true)]
@@ -145,9 +175,8 @@ pub struct Download {
 
 impl Execute for Download {
     fn pre_check(&mut self) -> Result<()> {
-        if !has_ffmpeg() {
-            bail!("FFmpeg is needed to run this command")
-        } else if Path::new(&self.output)
+        check_ffmpeg()?;
+        if Path::new(&self.output)
             .extension()
             .unwrap_or_default()
             .is_empty()
@@ -323,6 +352,9 @@ impl Execute for Download {
                     .ffmpeg_preset(self.ffmpeg_preset.clone().unwrap_or_default())
                     .ffmpeg_threads(self.ffmpeg_threads)
                     .threads(self.threads)
+                    .segment_retries(self.retries)
+                    .segment_retry_backoff(std::time::Duration::from_secs(self.retry_backoff))
+                    .segment_timeout(std::time::Duration::from_secs(self.segment_timeout))
                     .audio_locale_output_map(HashMap::from([(
                         self.audio.clone(),
                         self.output_audio_locale.clone(),
@@ -380,6 +412,16 @@ impl Execute for Download {
 
                 format.visual_output(&path);
 
+                if self.dry_run {
+                    let (segments, size) = downloader.estimate();
+                    tab_info!(
+                        "Estimated size: {} ({} segments)",
+                        HumanBytes(size),
+                        segments
+                    );
+                    continue;
+                }
+
                 downloader.download(&path).await?
             }
         }
@@ -460,6 +502,11 @@ async fn get_format(
             } else {
                 None
             },
+            tags: if download.include_metadata {
+                single_format.metadata_tags()
+            } else {
+                vec![]
+            },
         },
     };
     let mut format = Format::from_single_formats(vec![(
@@ -1,6 +1,7 @@
 use crate::utils::context::Context;
 use crate::utils::locale::system_locale;
 use crate::utils::log::{progress, CliLogger};
+use crate::utils::os::{output_tempfiles, temp_directory};
 use anyhow::bail;
 use anyhow::Result;
 use clap::{Parser, Subcommand};
@@ -9,7 +10,8 @@ use crunchyroll_rs::error::Error;
 use crunchyroll_rs::{Crunchyroll, Locale};
 use log::{debug, error, warn, LevelFilter};
 use reqwest::{Client, Proxy};
-use std::{env, fs};
+use std::fs;
+use std::time::Duration;
 
 mod archive;
 mod download;
@@ -79,6 +81,13 @@ pub struct Cli {
     #[arg(global = true, long, value_parser = crate::utils::clap::clap_parse_speed_limit)]
     speed_limit: Option<u32>,
 
+    #[arg(
+        help = "The time in seconds after which establishing a connection to a server is aborted"
+    )]
+    #[arg(global = true, long, default_value_t = 30)]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    connect_timeout: u64,
+
     #[clap(subcommand)]
     command: Command,
 }
@@ -174,7 +183,7 @@ pub async fn main(args: &[String]) {
 
     ctrlc::set_handler(move || {
         debug!("Ctrl-c detected");
-        if let Ok(dir) = fs::read_dir(env::temp_dir()) {
+        if let Ok(dir) = fs::read_dir(temp_directory()) {
             for file in dir.flatten() {
                 if file
                     .path()
@@ -210,6 +219,17 @@ pub async fn main(args: &[String]) {
                 }
             }
         }
+        // the output file is written to a temporary file in its destination directory before it
+        // gets renamed. only these exact files are removed there, as other files with the same
+        // prefix might belong to another running instance
+        for file in output_tempfiles() {
+            if fs::remove_file(&file).is_ok() {
+                debug!(
+                    "Ctrl-c removed temporary output file {}",
+                    file.to_string_lossy()
+                )
+            }
+        }
         // when pressing ctrl-c while interactively choosing seasons the cursor stays hidden, this
         // line shows it again
         let _ = Term::stdout().show_cursor();
@@ -253,10 +273,12 @@ async fn create_ctx(cli: &mut Cli) -> Result<Context> {
     let crunchy_client = reqwest_client(
         cli.proxy.as_ref().and_then(|p| p.0.clone()),
         cli.user_agent.clone(),
+        Duration::from_secs(cli.connect_timeout),
     );
     let internal_client = reqwest_client(
         cli.proxy.as_ref().and_then(|p| p.1.clone()),
         cli.user_agent.clone(),
+        Duration::from_secs(cli.connect_timeout),
     );
 
     let crunchy = crunchyroll_session(
@@ -378,7 +400,11 @@ async fn crunchyroll_session(
     Ok(crunchy)
 }
 
-fn reqwest_client(proxy: Option<Proxy>, user_agent: Option<String>) -> Client {
+fn reqwest_client(
+    proxy: Option<Proxy>,
+    user_agent: Option<String>,
+    connect_timeout: Duration,
+) -> Client {
     let mut builder = CrunchyrollBuilder::predefined_client_builder();
     if let Some(p) = proxy {
         builder = builder.proxy(p)
@@ -386,6 +412,12 @@ fn reqwest_client(proxy: Option<Proxy>, user_agent: Option<String>) -> Client {
     if let Some(ua) = user_agent {
         builder = builder.user_agent(ua)
     }
+    // a connection which stalls while being established would otherwise only be detected by the
+    // much longer request timeouts. keepalive probes are sent so that connections which died
+    // silently are detected
+    builder = builder
+        .connect_timeout(connect_timeout)
+        .tcp_keepalive(Duration::from_secs(60));
 
     #[cfg(any(feature = "openssl-tls", feature = "openssl-tls-static"))]
     let client = {
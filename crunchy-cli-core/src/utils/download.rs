@@ -2,34 +2,41 @@ use crate::utils::ffmpeg::FFmpegPreset;
 use crate::utils::filter::real_dedup_vec;
 use crate::utils::fmt::format_time_delta;
 use crate::utils::log::progress;
-use crate::utils::os::{cache_dir, is_special_file, temp_directory, temp_named_pipe, tempfile};
+use crate::utils::os::{
+    cache_dir, ffmpeg_path, is_special_file, output_tempfile_in, temp_directory, temp_named_pipe,
+    tempfile,
+};
 use crate::utils::rate_limit::RateLimiterService;
 use crate::utils::sync::{sync_audios, SyncAudio};
 use anyhow::{bail, Result};
-use chrono::{NaiveTime, TimeDelta};
+use chrono::{DateTime, NaiveTime, TimeDelta, Utc};
 use crunchyroll_rs::media::{SkipEvents, SkipEventsEvent, StreamData, StreamSegment, Subtitle};
 use crunchyroll_rs::Locale;
-use indicatif::{ProgressBar, ProgressDrawTarget, ProgressFinish, ProgressStyle};
+use indicatif::{HumanBytes, ProgressBar, ProgressDrawTarget, ProgressFinish, ProgressStyle};
 use log::{debug, warn, LevelFilter};
 use regex::Regex;
-use reqwest::Client;
+use reqwest::header::{CONTENT_RANGE, RANGE, RETRY_AFTER};
+use reqwest::{Client, Response, StatusCode};
 use rsubs_lib::{SSA, VTT};
 use std::borrow::Borrow;
 use std::cmp::Ordering;
+use std::collections::hash_map::RandomState;
 use std::collections::{BTreeMap, HashMap};
+use std::hash::{BuildHasher, Hasher};
 use std::io::Write;
 use std::ops::Add;
 use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
+use std::sync::atomic::{self, AtomicUsize};
 use std::sync::Arc;
-use std::time::Duration;
+use std::time::{Duration, Instant};
 use std::{env, fs};
 use tempfile::TempPath;
 use time::Time;
 use tokio::io::{AsyncBufReadExt, AsyncReadExt, BufReader};
 use tokio::select;
 use tokio::sync::mpsc::unbounded_channel;
-use tokio::sync::Mutex;
+use tokio::sync::{Mutex, Semaphore};
 use tokio::task::JoinSet;
 use tokio_util::sync::CancellationToken;
 use tower_service::Service;
@@ -70,6 +77,15 @@ pub struct DownloadBuilder {
     merge_sync_precision: Option<u32>,
     threads: usize,
     ffmpeg_threads: Option<usize>,
+    segment_retries: usize,
+    /// How long to wait before a failed segment is requested again. It's multiplied by the attempt
+    /// number of the upcoming retry, starting at 1, and randomized by ±50%.
+    segment_retry_backoff: Duration,
+    segment_timeout: Duration,
+    /// Maximal number of segments which are downloaded ahead of the segment which gets written
+    /// next. Limits how much memory is used if a single segment is slow to download. Raised to the
+    /// number of download threads if it's lower.
+    segment_buffer_size: usize,
     audio_locale_output_map: HashMap<Locale, String>,
     subtitle_locale_output_map: HashMap<Locale, String>,
 }
@@ -91,6 +107,10 @@ impl DownloadBuilder {
             merge_sync_precision: None,
             threads: num_cpus::get(),
             ffmpeg_threads: None,
+            segment_retries: 5,
+            segment_retry_backoff: Duration::from_secs(1),
+            segment_timeout: Duration::from_secs(60),
+            segment_buffer_size: 64,
             audio_locale_output_map: HashMap::new(),
             subtitle_locale_output_map: HashMap::new(),
         }
@@ -116,6 +136,11 @@ impl DownloadBuilder {
             download_threads: self.threads,
             ffmpeg_threads: self.ffmpeg_threads,
 
+            segment_retries: self.segment_retries,
+            segment_retry_backoff: self.segment_retry_backoff,
+            segment_timeout: self.segment_timeout,
+            segment_buffer_size: self.segment_buffer_size,
+
             formats: vec![],
 
             audio_locale_output_map: self.audio_locale_output_map,
@@ -145,6 +170,14 @@ struct FFmpegSubtitleMeta {
     video_idx: usize,
 }
 
+#[derive(Default)]
+struct SegmentStats {
+    downloaded: usize,
+    bytes: u64,
+    retries: usize,
+    slowest: (usize, Duration),
+}
+
 pub struct DownloadFormat {
     pub video: (StreamData, Locale),
     pub audios: Vec<(StreamData, Locale)>,
@@ -154,6 +187,7 @@ pub struct DownloadFormat {
 
 pub struct DownloadFormatMetadata {
     pub skip_events: Option<SkipEvents>,
+    pub tags: Vec<(String, String)>,
 }
 
 pub struct Downloader {
@@ -176,6 +210,11 @@ pub struct Downloader {
     download_threads: usize,
     ffmpeg_threads: Option<usize>,
 
+    segment_retries: usize,
+    segment_retry_backoff: Duration,
+    segment_timeout: Duration,
+    segment_buffer_size: usize,
+
     formats: Vec<DownloadFormat>,
 
     audio_locale_output_map: HashMap<Locale, String>,
@@ -187,10 +226,33 @@ impl Downloader {
         self.formats.push(format);
     }
 
+    /// Returns the number of segments and the estimated size in bytes of all video and audio
+    /// streams of the added formats.
+    pub fn estimate(&self) -> (usize, u64) {
+        let mut all_stream_data = vec![];
+        for format in &self.formats {
+            all_stream_data.push(&format.video.0);
+            all_stream_data.extend(format.audios.iter().map(|(a, _)| a))
+        }
+        let mut total_segments = 0;
+        let mut estimated_size = 0;
+        for stream_data in all_stream_data {
+            let segments = stream_data.segments();
+
+            // sum the length of all streams up
+            total_segments += segments.len();
+            estimated_size += estimate_stream_data_file_size(stream_data, &segments);
+        }
+        (total_segments, estimated_size)
+    }
+
     pub async fn download(mut self, dst: &Path) -> Result<()> {
         // `.unwrap_or_default()` here unless https://doc.rust-lang.org/stable/std/path/fn.absolute.html
         // gets stabilized as the function might throw error on weird file paths
-        let required = self.check_free_space(dst).await.unwrap_or_default();
+        let required = self.check_free_space(dst).await.unwrap_or_else(|e| {
+            debug!("Failed to check free disk space: {}", e);
+            Default::default()
+        });
         if let Some((path, tmp_required)) = &required.0 {
             let kb = (*tmp_required as f64) / 1024.0;
             let mb = kb / 1024.0;
@@ -400,6 +462,17 @@ impl Downloader {
                 .await?;
 
             let (len, fps) = get_video_stats(&path)?;
+            // a video which is noticeably shorter than the sum of its segments is a hint that
+            // segments got lost or are corrupted
+            let expected_len = len_from_segments(&format.video.0.segments());
+            if expected_len - len > TimeDelta::seconds(5) {
+                warn!(
+                    "Video #{} is shorter than expected ({} instead of {}), it might be corrupted",
+                    i + 1,
+                    format_time_delta(&len),
+                    format_time_delta(&expected_len)
+                )
+            }
             if max_len < len {
                 max_len = len
             }
@@ -675,6 +748,14 @@ impl Downloader {
             ])
         }
 
+        // all formats are from the same episode, so the tags of the first one are used as global
+        // metadata of the output file
+        if let Some(format) = self.formats.first() {
+            for (key, value) in &format.metadata.tags {
+                metadata.extend(["-metadata".to_string(), format!("{}={}", key, value)])
+            }
+        }
+
         let preset_custom = matches!(self.ffmpeg_preset, FFmpegPreset::Custom(_));
         let (input_presets, mut output_presets) = self.ffmpeg_preset.into_input_output_args();
         let fifo = temp_named_pipe()?;
@@ -781,28 +862,47 @@ impl Downloader {
             command_args.extend(["-f".to_string(), output_format]);
         }
 
+        // create parent directory if it does not exist
+        if let Some(parent) = dst.parent() {
+            if !parent.exists() {
+                fs::create_dir_all(parent)?
+            }
+        }
+
+        // ffmpeg writes into a temporary file next to the destination, which is renamed to the
+        // destination after ffmpeg has finished successfully. this way no partially written file is
+        // left at the destination if ffmpeg fails or the process gets killed. the temporary file
+        // must have the same extension as the destination as ffmpeg detects the output format by it
+        let tmp_dst = if is_special_file(dst) || dst.to_str().unwrap() == "-" {
+            None
+        } else {
+            Some(
+                output_tempfile_in(
+                    dst.parent()
+                        .filter(|p| !p.to_string_lossy().is_empty())
+                        .unwrap_or(Path::new(".")),
+                    format!(".{}", dst.extension().unwrap_or_default().to_string_lossy()),
+                )?
+                .into_temp_path(),
+            )
+        };
+        let output = tmp_dst.as_ref().map_or(dst, |p| p.as_ref());
+
         // prepend './' to the path on linux since ffmpeg may interpret the path incorrectly if it's just the filename.
         // see https://github.com/crunchy-labs/crunchy-cli/issues/303 for example
         if !cfg!(windows)
-            && dst
+            && output
                 .parent()
                 .map_or(true, |p| p.to_string_lossy().is_empty())
         {
-            command_args.push(Path::new("./").join(dst).to_string_lossy().to_string());
+            command_args.push(Path::new("./").join(output).to_string_lossy().to_string());
         } else {
-            command_args.push(dst.to_string_lossy().to_string())
+            command_args.push(output.to_string_lossy().to_string())
         }
 
         debug!("ffmpeg {}", command_args.join(" "));
 
-        // create parent directory if it does not exist
-        if let Some(parent) = dst.parent() {
-            if !parent.exists() {
-                fs::create_dir_all(parent)?
-            }
-        }
-
-        let ffmpeg = Command::new("ffmpeg")
+        let ffmpeg = Command::new(ffmpeg_path())
             // pass ffmpeg stdout to real stdout only if output file is stdout
             .stdout(if dst.to_str().unwrap() == "-" {
                 Stdio::inherit()
@@ -830,27 +930,24 @@ impl Downloader {
             bail!("{}", String::from_utf8_lossy(result.stderr.as_slice()))
         }
         ffmpeg_progress_cancel.cancel();
-        ffmpeg_progress.await?
+        ffmpeg_progress.await??;
+
+        if let Some(tmp_dst) = tmp_dst {
+            tmp_dst.persist(dst)?
+        }
+
+        debug!("Generated output file {}", dst.to_string_lossy());
+
+        Ok(())
     }
 
     async fn check_free_space(
         &self,
         dst: &Path,
     ) -> Result<(Option<(PathBuf, u64)>, Option<(PathBuf, u64)>)> {
-        let mut all_stream_data = vec![];
-        for format in &self.formats {
-            all_stream_data.push(&format.video.0);
-            all_stream_data.extend(format.audios.iter().map(|(a, _)| a))
-        }
-        let mut estimated_required_space: u64 = 0;
-        for stream_data in all_stream_data {
-            let segments = stream_data.segments();
-
-            // sum the length of all streams up
-            estimated_required_space += estimate_stream_data_file_size(stream_data, &segments);
-        }
+        let (_, estimated_required_space) = self.estimate();
 
-        let tmp_stat = fs2::statvfs(temp_directory()).unwrap();
+        let tmp_stat = fs2::statvfs(temp_directory())?;
         let mut dst_file = if dst.is_absolute() {
             dst.to_path_buf()
         } else {
@@ -862,7 +959,7 @@ impl Downloader {
                 break;
             }
         }
-        let dst_stat = fs2::statvfs(&dst_file).unwrap();
+        let dst_stat = fs2::statvfs(&dst_file)?;
 
         let mut tmp_space = tmp_stat.available_space();
         let mut dst_space = dst_stat.available_space();
@@ -1003,12 +1100,17 @@ impl Downloader {
         let mut segments = stream_data.segments();
         if let Some(max_segments) = max_segments {
             segments = segments
-                .drain(0..max_segments.min(segments.len() - 1))
+                .drain(0..max_segments.min(segments.len().saturating_sub(1)))
                 .collect();
         }
+        // without any segment the download would 'successfully' result in an empty file
+        if segments.is_empty() {
+            bail!("Stream contains no segments")
+        }
         let total_segments = segments.len();
 
-        let count = Arc::new(Mutex::new(0));
+        let stats = Arc::new(Mutex::new(SegmentStats::default()));
+        let start = Instant::now();
 
         let progress = if log::max_level() == LevelFilter::Info {
             let estimated_file_size = estimate_stream_data_file_size(stream_data, &segments);
@@ -1016,7 +1118,7 @@ impl Downloader {
             let progress = ProgressBar::new(estimated_file_size)
                 .with_style(
                     ProgressStyle::with_template(
-                        ":: {msg} {bytes:>10} {bytes_per_sec:>12} [{wide_bar}] {percent:>3}%",
+                        ":: {msg} {bytes:>10} {bytes_per_sec:>12} [{wide_bar}] {percent:>3}% {eta:>4}",
                     )
                     .unwrap()
                     .progress_chars("##-"),
@@ -1028,24 +1130,47 @@ impl Downloader {
             None
         };
 
-        let cpus = self.download_threads.min(segments.len());
-        let mut segs: Vec<Vec<StreamSegment>> = Vec::with_capacity(cpus);
-        for _ in 0..cpus {
-            segs.push(vec![])
-        }
-        for (i, segment) in segments.clone().into_iter().enumerate() {
-            segs[i - ((i / cpus) * cpus)].push(segment);
+        // zero threads would mean that nothing gets downloaded at all, so it is treated as "use the
+        // default" instead
+        let cpus = if self.download_threads == 0 {
+            num_cpus::get()
+        } else {
+            self.download_threads
         }
+        .min(segments.len());
+        // the segments aren't split up between the threads beforehand. instead, every thread takes
+        // the next segment which isn't downloaded yet when it has finished its current one. this
+        // prevents that threads are idling while others still have multiple segments left, e.g.
+        // because some of their segments were slow to download
+        let segments = Arc::new(segments);
+        let next_segment = Arc::new(AtomicUsize::new(0));
+        // every segment which is downloading or waiting in the write buffer holds a permit. if an
+        // early segment is slow, the other threads therefore stop taking new segments once the
+        // buffer is full instead of filling up the memory with segments which can't be written yet
+        let buffer_permits = Arc::new(Semaphore::new(self.segment_buffer_size.max(cpus)));
+
+        debug!(
+            "Downloading {} segments with {} threads",
+            total_segments, cpus
+        );
 
         let (sender, mut receiver) = unbounded_channel();
+        let cancellation_token = CancellationToken::new();
 
         let mut join_set: JoinSet<Result<()>> = JoinSet::new();
-        for num in 0..cpus {
+        for _ in 0..cpus {
             let thread_sender = sender.clone();
-            let thread_segments = segs.remove(0);
+            let thread_segments = segments.clone();
+            let thread_next_segment = next_segment.clone();
+            let thread_buffer_permits = buffer_permits.clone();
             let thread_client = self.client.clone();
             let mut thread_rate_limiter = self.rate_limiter.clone();
-            let thread_count = count.clone();
+            let thread_stats = stats.clone();
+            let thread_progress = progress.clone();
+            let thread_cancellation_token = cancellation_token.clone();
+            let thread_retries = self.segment_retries;
+            let thread_retry_backoff = self.segment_retry_backoff;
+            let thread_timeout = self.segment_timeout;
             join_set.spawn(async move {
                 let after_download_sender = thread_sender.clone();
 
@@ -1053,57 +1178,212 @@ impl Downloader {
                 // catch errors which get returned with `...?` and `bail!(...)` and that the thread
                 // itself can report that an error has occurred
                 let download = || async move {
-                    for (i, segment) in thread_segments.into_iter().enumerate() {
+                    loop {
+                        // the permit must be acquired before the segment number is taken. this way
+                        // the segment which gets written next always has a permit and the download
+                        // can't get stuck
+                        let permit = thread_buffer_permits.clone().acquire_owned().await?;
+                        let i = thread_next_segment.fetch_add(1, atomic::Ordering::SeqCst);
+                        let Some(segment) = thread_segments.get(i) else {
+                            break;
+                        };
+                        debug!("Downloading segment {} ({})", i + 1, segment.url);
+                        let segment_start = Instant::now();
+
                         let mut retry_count = 0;
+                        // chunks which were received by previous, failed attempts are kept, so that a
+                        // retry only has to request the missing part of the segment
+                        let mut chunks = vec![];
+                        let mut received = 0;
+                        // the host which actually served the segment. it's only used for debug
+                        // output to make it possible to identify misbehaving cdn servers
+                        let mut host;
                         let buf = loop {
-                            let request = thread_client
+                            let mut request = thread_client
                                 .get(&segment.url)
-                                .timeout(Duration::from_secs(60));
+                                .timeout(thread_timeout);
+                            if received > 0 {
+                                request = request.header(RANGE, format!("bytes={}-", received))
+                            }
                             let response = if let Some(rate_limiter) = &mut thread_rate_limiter {
                                 rate_limiter.call(request.build()?).await.map_err(anyhow::Error::new)
                             } else {
                                 request.send().await.map_err(anyhow::Error::new)
                             };
 
+                            // a server provided wait time is limited to the segment timeout, as it
+                            // could stall the thread for an unreasonable time otherwise
+                            let retry_after = response
+                                .as_ref()
+                                .ok()
+                                .and_then(parse_retry_after)
+                                .map(|d| d.min(thread_timeout));
                             let err = match response {
-                                Ok(r) => match r.bytes().await {
-                                    Ok(b) => break b.to_vec(),
-                                    Err(e) => anyhow::Error::new(e)
+                                // error status codes are turned into errors here as their body is
+                                // not segment data but a (most of the time html) error page
+                                Ok(r) => match r.error_for_status() {
+                                    Ok(mut r) => {
+                                        // this is the url after all redirects were followed
+                                        host = r.url().host_str().unwrap_or_default().to_string();
+
+                                        // if the server does not support range requests, the whole
+                                        // segment is sent again and the already received chunks
+                                        // (and their reported progress) must be discarded. the same
+                                        // applies if the server sent a different range than requested
+                                        let content_range = if r.status() == StatusCode::PARTIAL_CONTENT {
+                                            parse_content_range(&r)
+                                        } else {
+                                            None
+                                        };
+                                        if received > 0 && content_range.map(|(start, _)| start) != Some(received) {
+                                            if let Some(p) = &thread_progress {
+                                                p.dec(received)
+                                            }
+                                            chunks.clear();
+                                            received = 0;
+                                        }
+
+                                        // a partial response is validated against the total size of
+                                        // the segment instead of only the length of its own body
+                                        let expected_length = if received > 0 {
+                                            content_range
+                                                .and_then(|(_, total)| total)
+                                                .or_else(|| r.content_length().map(|l| received + l))
+                                        } else {
+                                            r.content_length()
+                                        };
+
+                                        // the body is read chunk by chunk to update the progress bar
+                                        // continuously and not only when a whole segment is finished
+                                        let mut response_received = 0;
+                                        let result = loop {
+                                            match r.chunk().await {
+                                                Ok(Some(chunk)) => {
+                                                    if let Some(p) = &thread_progress {
+                                                        p.inc(chunk.len() as u64)
+                                                    }
+                                                    received += chunk.len() as u64;
+                                                    response_received += chunk.len() as u64;
+                                                    chunks.push(chunk)
+                                                }
+                                                Ok(None) => {
+                                                    // some aborted responses are successful but have no
+                                                    // body at all, which would result in a missing
+                                                    // segment (or part of it) in the output file
+                                                    if response_received == 0 {
+                                                        break Err(anyhow::anyhow!("Received empty segment"))
+                                                    }
+                                                    // a connection which gets closed after the headers
+                                                    // were sent results in a truncated body without any
+                                                    // error, so the length is validated manually
+                                                    if let Some(expected_length) = expected_length {
+                                                        if received != expected_length {
+                                                            break Err(anyhow::anyhow!("Received {} bytes but expected {}", received, expected_length))
+                                                        }
+                                                    }
+                                                    break Ok(())
+                                                }
+                                                Err(e) => break Err(anyhow::Error::new(e))
+                                            }
+                                        };
+                                        match result {
+                                            Ok(()) => break chunks,
+                                            Err(e) => e
+                                        }
+                                    }
+                                    Err(e) => {
+                                        // client errors (e.g. 403 or 404) won't change by requesting
+                                        // the segment again, so the download is aborted directly.
+                                        // request timeouts and rate limits are the exceptions of
+                                        // this as they are temporary
+                                        if let Some(status) = e.status() {
+                                            if status.is_client_error()
+                                                && status != StatusCode::REQUEST_TIMEOUT
+                                                && status != StatusCode::TOO_MANY_REQUESTS
+                                                && status != StatusCode::RANGE_NOT_SATISFIABLE
+                                            {
+                                                bail!("Failed to download segment {}: {}", i + 1, e)
+                                            }
+                                        }
+                                        // a range which cannot be satisfied means that the already
+                                        // received chunks are unusable, so the next attempt has to
+                                        // start from the beginning again
+                                        if e.status() == Some(StatusCode::RANGE_NOT_SATISFIABLE) {
+                                            if let Some(p) = &thread_progress {
+                                                p.dec(received)
+                                            }
+                                            chunks.clear();
+                                            received = 0;
+                                        }
+                                        anyhow::Error::new(e)
+                                    }
                                 }
                                 Err(e) => e,
                             };
 
-                            if retry_count == 5 {
-                                bail!("Max retry count reached ({}), multiple errors occurred while receiving segment {}: {}", retry_count, num + (i * cpus), err)
+                            if retry_count == thread_retries {
+                                bail!("Max retry count reached ({}), multiple errors occurred while receiving segment {}: {}", retry_count, i + 1, err)
                             }
-                            debug!("Failed to download segment {} ({}). Retrying, {} out of 5 retries left", num + (i * cpus), err, 5 - retry_count);
+                            debug!("Failed to download segment {} ({}). Retrying, {} out of {} retries left", i + 1, err, thread_retries - retry_count, thread_retries);
 
                             retry_count += 1;
+                            thread_stats.lock().await.retries += 1;
+                            // retries would otherwise only be noticeable as a stalling progress bar
+                            if let Some(p) = &thread_progress {
+                                p.println(format!(":: Retrying segment {} (attempt {}/{}): {}", i + 1, retry_count, thread_retries, err))
+                            }
+                            // the backoff grows with every retry, so it's capped to not overflow (or
+                            // stall forever) on many retries
+                            let backoff = thread_retry_backoff
+                                .saturating_mul(u32::try_from(retry_count).unwrap_or(u32::MAX))
+                                .min(Duration::from_secs(60 * 60));
+                            // if the server told how long to wait, this is preferred over the
+                            // configured backoff
+                            tokio::time::sleep(retry_after.unwrap_or_else(|| jitter(backoff))).await;
                         };
 
-                        let mut c = thread_count.lock().await;
+                        let download_time = segment_start.elapsed();
+                        let segment_bytes = buf.iter().map(|b| b.len() as u64).sum::<u64>();
+                        let mut s = thread_stats.lock().await;
                         debug!(
-                            "Downloaded segment [{}/{} {:.2}%] {}",
-                            num + (i * cpus) + 1,
+                            "Downloaded segment [{}/{} {:.2}%] ({} bytes, {:.2}s long, took {:.2}s, served by {}) {}",
+                            i + 1,
                             total_segments,
-                            ((*c + 1) as f64 / total_segments as f64) * 100f64,
+                            ((s.downloaded + 1) as f64 / total_segments as f64) * 100f64,
+                            segment_bytes,
+                            segment.length.as_secs_f64(),
+                            download_time.as_secs_f64(),
+                            host,
                             segment.url
                         );
 
-                        thread_sender.send((num as i32 + (i * cpus) as i32, buf))?;
+                        s.bytes += segment_bytes;
+                        if download_time > s.slowest.1 {
+                            s.slowest = (i, download_time)
+                        }
 
-                        *c += 1;
+                        thread_sender.send(Ok((i as i32, buf, permit)))?;
+
+                        s.downloaded += 1;
                     }
                     Ok(())
                 };
 
 
-                let result = download().await;
-                if result.is_err() {
-                    after_download_sender.send((-1, vec![]))?;
+                // the download is raced against the cancellation token, so that requests which are
+                // still in-flight get aborted immediately if another thread failed instead of
+                // finishing all remaining segments of this thread first
+                let result = select! {
+                    _ = thread_cancellation_token.cancelled() => return Ok(()),
+                    result = download() => result,
+                };
+                // the error is sent to the main loop instead of being returned, so that the error
+                // which actually stopped the download is the one which gets reported
+                if let Err(e) = result {
+                    after_download_sender.send(Err(e))?;
                 }
 
-                result
+                Ok(())
             });
         }
         // drop the sender already here so it does not outlive all download threads which are the only
@@ -1112,37 +1392,55 @@ impl Downloader {
 
         // this is the main loop which writes the data. it uses a BTreeMap as a buffer as the write
         // happens synchronized. the download consist of multiple segments. the map keys are representing
-        // the segment number and the values the corresponding bytes
+        // the segment number and the values the corresponding bytes. the bytes are kept as the chunks
+        // they were received from the response instead of being copied into a new vec, so that every
+        // segment only lives once in memory
         let mut data_pos = 0;
-        let mut buf: BTreeMap<i32, Vec<u8>> = BTreeMap::new();
-        while let Some((pos, bytes)) = receiver.recv().await {
-            // if the position is lower than 0, an error occurred in the sending download thread
-            if pos < 0 {
-                break;
-            }
+        let mut buf = BTreeMap::new();
+        let mut download_error = None;
+        while let Some(received) = receiver.recv().await {
+            // an error occurred in the sending download thread. all other threads are cancelled as
+            // their result isn't needed anymore
+            let (pos, bytes, permit) = match received {
+                Ok(received) => received,
+                Err(e) => {
+                    cancellation_token.cancel();
+                    download_error = Some(e);
+                    break;
+                }
+            };
 
             if let Some(p) = &progress {
                 let progress_len = p.length().unwrap();
                 let estimated_segment_len = (stream_data.bandwidth / 8)
                     * segments.get(pos as usize).unwrap().length.as_secs();
-                let bytes_len = bytes.len() as u64;
+                let bytes_len = bytes.iter().map(|b| b.len() as u64).sum::<u64>();
 
+                // the progress itself is already increased by the download threads, only the
+                // estimated length gets corrected here
                 p.set_length(progress_len - estimated_segment_len + bytes_len);
-                p.inc(bytes_len)
             }
 
             // check if the currently sent bytes are the next in the buffer. if so, write them directly
             // to the target without first adding them to the buffer.
             // if not, add them to the buffer
             if data_pos == pos {
-                writer.write_all(bytes.borrow())?;
+                for chunk in bytes {
+                    writer.write_all(chunk.borrow())?;
+                }
                 data_pos += 1;
+                drop(permit)
             } else {
-                buf.insert(pos, bytes);
+                // the permit is kept alongside the buffered segment. it's only dropped when the
+                // segment got written, which frees its place in the buffer
+                buf.insert(pos, (bytes, permit));
             }
-            // check if the buffer contains the next segment(s)
-            while let Some(b) = buf.remove(&data_pos) {
-                writer.write_all(b.borrow())?;
+            // check if the buffer contains the next segment(s). the permit of a segment is dropped
+            // after it was written
+            while let Some((b, _permit)) = buf.remove(&data_pos) {
+                for chunk in b {
+                    writer.write_all(chunk.borrow())?;
+                }
                 data_pos += 1;
             }
         }
@@ -1151,10 +1449,15 @@ impl Downloader {
         while let Some(joined) = join_set.join_next().await {
             joined??
         }
+        if let Some(e) = download_error {
+            return Err(e);
+        }
 
         // write the remaining buffer, if existent
-        while let Some(b) = buf.remove(&data_pos) {
-            writer.write_all(b.borrow())?;
+        while let Some((b, _)) = buf.remove(&data_pos) {
+            for chunk in b {
+                writer.write_all(chunk.borrow())?;
+            }
             data_pos += 1;
         }
 
@@ -1162,16 +1465,69 @@ impl Downloader {
             bail!(
                 "Download buffer is not empty. Remaining segments: {}",
                 buf.into_keys()
-                    .map(|k| k.to_string())
+                    .map(|k| (k + 1).to_string())
                     .collect::<Vec<String>>()
                     .join(", ")
             )
         }
 
+        let elapsed = start.elapsed();
+        let stats = stats.lock().await;
+        debug!(
+            "Downloaded {} segments ({}) in {:.2}s with {} retries. Average speed was {}/s, slowest segment was {} ({:.2}s)",
+            stats.downloaded,
+            HumanBytes(stats.bytes),
+            elapsed.as_secs_f64(),
+            stats.retries,
+            HumanBytes((stats.bytes as f64 / elapsed.as_secs_f64()) as u64),
+            stats.slowest.0 + 1,
+            stats.slowest.1.as_secs_f64()
+        );
+
         Ok(())
     }
 }
 
+/// Randomizes the given duration by ±50%. This prevents that segments which failed at the same
+/// time (e.g. because of a short cdn outage) are all retried at the same time again.
+fn jitter(duration: Duration) -> Duration {
+    // `RandomState` is randomly seeded, which is random enough for this and saves a dependency
+    let random = RandomState::new().build_hasher().finish();
+    duration.mul_f64(0.5 + random as f64 / u64::MAX as f64)
+}
+
+/// Get the time to wait before the next request from the `Retry-After` header of a rate limited
+/// (429) or unavailable (503) response. The header value can be either seconds or a http date. A
+/// date which is already in the past means that no waiting is required.
+fn parse_retry_after(response: &Response) -> Option<Duration> {
+    if response.status() != StatusCode::TOO_MANY_REQUESTS
+        && response.status() != StatusCode::SERVICE_UNAVAILABLE
+    {
+        return None;
+    }
+
+    let retry_after = response.headers().get(RETRY_AFTER)?.to_str().ok()?;
+    if let Ok(secs) = retry_after.parse() {
+        Some(Duration::from_secs(secs))
+    } else {
+        let date = DateTime::parse_from_rfc2822(retry_after).ok()?;
+        Some(
+            (date.with_timezone(&Utc) - Utc::now())
+                .to_std()
+                .unwrap_or_default(),
+        )
+    }
+}
+
+/// Get the first byte position and, if known, the total size of a partial (206) response from its
+/// `Content-Range` header, e.g. `(1000, Some(2000))` from `bytes 1000-1999/2000`.
+fn parse_content_range(response: &Response) -> Option<(u64, Option<u64>)> {
+    let content_range = response.headers().get(CONTENT_RANGE)?.to_str().ok()?;
+    let (range, total) = content_range.strip_prefix("bytes ")?.split_once('/')?;
+    let (start, _) = range.split_once('-')?;
+    Some((start.trim().parse().ok()?, total.trim().parse().ok()))
+}
+
 fn estimate_stream_data_file_size(stream_data: &StreamData, segments: &[StreamSegment]) -> u64 {
     (stream_data.bandwidth / 8) * segments.iter().map(|s| s.length.as_secs()).sum::<u64>()
 }
@@ -1181,7 +1537,7 @@ fn get_video_stats(path: &Path) -> Result<(TimeDelta, f64)> {
     let video_length = Regex::new(r"Duration:\s(?P<time>\d+:\d+:\d+\.\d+),")?;
     let video_fps = Regex::new(r"(?P<fps>[\d/.]+)\sfps")?;
 
-    let ffmpeg = Command::new("ffmpeg")
+    let ffmpeg = Command::new(ffmpeg_path())
         .stdout(Stdio::null())
         .stderr(Stdio::piped())
         .arg("-y")
@@ -72,7 +72,8 @@ ffmpeg_enum! {
     enum FFmpegHwAccel {
         Nvidia,
         Amd,
-        Apple
+        Apple,
+        Intel
     }
 }
 
@@ -106,7 +107,7 @@ impl FFmpegPreset {
             ),
             (
                 FFmpegCodec::Av1,
-                vec![FFmpegHwAccel::Amd],
+                vec![FFmpegHwAccel::Amd, FFmpegHwAccel::Intel],
                 FFmpegQuality::all(),
             ),
         ];
@@ -288,6 +289,21 @@ impl FFmpegPreset {
 
                                     output.extend(["-c:v", "h264_videotoolbox", "-c:a", "copy"])
                                 }
+                                FFmpegHwAccel::Intel => {
+                                    // Intel's Quick Sync Video encoders ignore `-crf`, the
+                                    // equivalent is `-global_quality` which uses the same scale
+                                    match quality {
+                                        FFmpegQuality::Lossless => {
+                                            output.extend(["-global_quality", "18"])
+                                        }
+                                        FFmpegQuality::Normal => (),
+                                        FFmpegQuality::Low => {
+                                            output.extend(["-global_quality", "35"])
+                                        }
+                                    }
+
+                                    output.extend(["-c:v", "h264_qsv", "-c:a", "copy"])
+                                }
                             }
                         } else {
                             crf_quality();
@@ -343,6 +359,22 @@ impl FFmpegPreset {
                                         "hvc1",
                                     ])
                                 }
+                                FFmpegHwAccel::Intel => {
+                                    // See the comment for intel h264 hwaccel
+                                    match quality {
+                                        FFmpegQuality::Lossless => {
+                                            output.extend(["-global_quality", "20"])
+                                        }
+                                        FFmpegQuality::Normal => (),
+                                        FFmpegQuality::Low => {
+                                            output.extend(["-global_quality", "35"])
+                                        }
+                                    }
+
+                                    output.extend([
+                                        "-c:v", "hevc_qsv", "-c:a", "copy", "-tag:v", "hvc1",
+                                    ])
+                                }
                             }
                         } else {
                             crf_quality();
@@ -356,11 +388,27 @@ impl FFmpegPreset {
                             FFmpegQuality::Low => output.extend(["-crf", "35"]),
                         };
 
-                        crf_quality();
-                        if let Some(FFmpegHwAccel::Amd) = hwaccel_opt {
-                            output.extend(["-c:v", "av1_amf", "-c:a", "copy"]);
-                        } else {
-                            output.extend(["-c:v", "libsvtav1", "-c:a", "copy"]);
+                        match hwaccel_opt {
+                            Some(FFmpegHwAccel::Amd) => {
+                                crf_quality();
+                                output.extend(["-c:v", "av1_amf", "-c:a", "copy"]);
+                            }
+                            Some(FFmpegHwAccel::Intel) => {
+                                // See the comment for intel h264 hwaccel
+                                match quality {
+                                    FFmpegQuality::Lossless => {
+                                        output.extend(["-global_quality", "22"])
+                                    }
+                                    FFmpegQuality::Normal => (),
+                                    FFmpegQuality::Low => output.extend(["-global_quality", "35"]),
+                                }
+
+                                output.extend(["-c:v", "av1_qsv", "-c:a", "copy"]);
+                            }
+                            _ => {
+                                crf_quality();
+                                output.extend(["-c:v", "libsvtav1", "-c:a", "copy"]);
+                            }
                         }
                     }
                 }
@@ -1,4 +1,5 @@
 use crate::utils::filter::real_dedup_vec;
+use crate::utils::fmt::format_time_delta;
 use crate::utils::locale::LanguageTagging;
 use crate::utils::log::tab_info;
 use crate::utils::os::{is_special_file, sanitize};
@@ -191,6 +192,31 @@ impl SingleFormat {
         }
     }
 
+    /// Metadata tags which are written into the output file. They are used by media servers (like
+    /// plex or jellyfin) to identify what the file contains.
+    pub fn metadata_tags(&self) -> Vec<(String, String)> {
+        let mut tags = vec![
+            ("title".to_string(), self.title.clone()),
+            ("description".to_string(), self.description.clone()),
+            ("show".to_string(), self.series_name.clone()),
+            ("season_number".to_string(), self.season_number.to_string()),
+            (
+                "date".to_string(),
+                format!(
+                    "{}-{:0>2}-{:0>2}",
+                    self.release_year, self.release_month, self.release_day
+                ),
+            ),
+        ];
+        // the episode number isn't always a number (e.g. 'SP' for specials), but the episode sort
+        // tag must be one
+        if let Ok(episode_number) = self.episode_number.parse::<u32>() {
+            tags.push(("episode_sort".to_string(), episode_number.to_string()))
+        }
+        tags.retain(|(_, value)| !value.is_empty());
+        tags
+    }
+
     pub fn source_type(&self) -> String {
         match &self.source {
             MediaCollection::Episode(_) => "episode",
@@ -359,6 +385,7 @@ pub struct Format {
     pub width: u64,
     pub height: u64,
     pub fps: f64,
+    pub duration: Duration,
 
     pub release_year: u64,
     pub release_month: u64,
@@ -404,6 +431,7 @@ impl Format {
             width: first_stream.resolution().unwrap().width,
             height: first_stream.resolution().unwrap().height,
             fps: first_stream.fps().unwrap(),
+            duration: first_format.duration,
             release_year: first_format.release_year,
             release_month: first_format.release_month,
             release_day: first_format.release_day,
@@ -588,8 +616,9 @@ impl Format {
                 .collect::<Vec<String>>()
                 .join(", ")
         );
-        tab_info!("Resolution: {}x{}", self.height, self.width);
-        tab_info!("FPS: {:.2}", self.fps)
+        tab_info!("Resolution: {}x{}", self.width, self.height);
+        tab_info!("FPS: {:.2}", self.fps);
+        tab_info!("Duration: {}", format_time_delta(&self.duration))
     }
 
     pub fn is_special(&self) -> bool {
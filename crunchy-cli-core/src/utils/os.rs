@@ -1,27 +1,44 @@
+use anyhow::bail;
 use log::debug;
 use regex::{Regex, RegexBuilder};
 use std::borrow::Cow;
+use std::collections::HashSet;
 use std::io::ErrorKind;
 use std::path::{Path, PathBuf};
 use std::pin::Pin;
 use std::process::{Command, Stdio};
+use std::sync::Mutex;
 use std::task::{Context, Poll};
 use std::{env, fs, io};
 use tempfile::{Builder, NamedTempFile, TempPath};
 use tokio::io::{AsyncRead, ReadBuf};
 
-pub fn has_ffmpeg() -> bool {
-    if let Err(e) = Command::new("ffmpeg").stderr(Stdio::null()).spawn() {
-        if ErrorKind::NotFound != e.kind() {
-            debug!(
-                "unknown error occurred while checking if ffmpeg exists: {}",
-                e.kind()
-            )
-        }
-        false
-    } else {
-        true
+/// Check if ffmpeg can be executed. If a custom binary is set via `CRUNCHY_CLI_FFMPEG`, the
+/// returned error contains its path and the reason why it can't be executed.
+pub fn check_ffmpeg() -> anyhow::Result<()> {
+    let Err(e) = Command::new(ffmpeg_path()).stderr(Stdio::null()).spawn() else {
+        return Ok(());
+    };
+    if env::var_os("CRUNCHY_CLI_FFMPEG").is_some() {
+        bail!(
+            "FFmpeg binary '{}' (set via CRUNCHY_CLI_FFMPEG) cannot be executed: {}",
+            ffmpeg_path().to_string_lossy(),
+            e
+        )
     }
+    if ErrorKind::NotFound != e.kind() {
+        debug!(
+            "unknown error occurred while checking if ffmpeg exists: {}",
+            e.kind()
+        )
+    }
+    bail!("FFmpeg is needed to run this command")
+}
+
+/// Get the ffmpeg binary either by the specified `CRUNCHY_CLI_FFMPEG` env variable or the one
+/// which is in `PATH`.
+pub fn ffmpeg_path() -> PathBuf {
+    env::var("CRUNCHY_CLI_FFMPEG").map_or(PathBuf::from("ffmpeg"), PathBuf::from)
 }
 
 /// Get the temp directory either by the specified `CRUNCHY_CLI_TEMP_DIR` env variable or the dir
@@ -30,15 +47,55 @@ pub fn temp_directory() -> PathBuf {
     env::var("CRUNCHY_CLI_TEMP_DIR").map_or(env::temp_dir(), PathBuf::from)
 }
 
+lazy_static::lazy_static! {
+    static ref OUTPUT_TEMPFILES: Mutex<HashSet<PathBuf>> = Mutex::new(HashSet::new());
+}
+
+/// Get all files which were created with [`output_tempfile_in`]. They are outside the temp
+/// directory, so they must be removed by their path in a case of ctrl-c. Files which got persisted
+/// in the meantime don't exist anymore under this path.
+pub fn output_tempfiles() -> Vec<PathBuf> {
+    OUTPUT_TEMPFILES.lock().unwrap().iter().cloned().collect()
+}
+
 /// Any tempfile should be created with this function. The prefix and directory of every file
 /// created with this function stays the same which is helpful to query all existing tempfiles and
 /// e.g. remove them in a case of ctrl-c. Having one function also good to prevent mistakes like
 /// setting the wrong prefix if done manually.
 pub fn tempfile<S: AsRef<str>>(suffix: S) -> io::Result<NamedTempFile> {
-    let tempfile = Builder::default()
+    create_tempfile(&mut Builder::default(), temp_directory(), suffix.as_ref())
+}
+
+/// Like [`tempfile`] but the file is created in the given directory and gets the permissions a
+/// regular new file would get instead of being only accessible by the current user. Use this for
+/// files which are moved to their final destination afterwards. The path of the file is
+/// remembered, so that it gets removed in a case of ctrl-c too (see [`output_tempfiles`]).
+pub fn output_tempfile_in<P: AsRef<Path>, S: AsRef<str>>(
+    dir: P,
+    suffix: S,
+) -> io::Result<NamedTempFile> {
+    let mut builder = Builder::default();
+    // the umask is applied to the permissions when the file is created, so the result is the same
+    // as if the file would have been created by any other program
+    #[cfg(not(target_os = "windows"))]
+    builder.permissions(std::os::unix::fs::PermissionsExt::from_mode(0o666));
+    let tempfile = create_tempfile(&mut builder, dir, suffix.as_ref())?;
+    OUTPUT_TEMPFILES
+        .lock()
+        .unwrap()
+        .insert(tempfile.path().to_path_buf());
+    Ok(tempfile)
+}
+
+fn create_tempfile<'a, P: AsRef<Path>>(
+    builder: &mut Builder<'_, 'a>,
+    dir: P,
+    suffix: &'a str,
+) -> io::Result<NamedTempFile> {
+    let tempfile = builder
         .prefix(".crunchy-cli_")
-        .suffix(suffix.as_ref())
-        .tempfile_in(temp_directory())?;
+        .suffix(suffix)
+        .tempfile_in(dir)?;
     debug!(
         "Created temporary file: {}",
         tempfile.path().to_string_lossy()
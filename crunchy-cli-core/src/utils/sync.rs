@@ -18,6 +18,7 @@ use anyhow::{bail, Result};
 use rusty_chromaprint::{Configuration, Fingerprinter};
 
 use super::fmt::format_time_delta;
+use super::os::ffmpeg_path;
 
 pub struct SyncAudio {
     pub format_id: usize,
@@ -229,7 +230,7 @@ fn generate_chromaprint(
     let mut printer = Fingerprinter::new(&Configuration::preset_test1());
     printer.start(sample_rate, 2)?;
 
-    let mut command = Command::new("ffmpeg");
+    let mut command = Command::new(ffmpeg_path());
     command
         .arg("-hide_banner")
         .arg("-y")
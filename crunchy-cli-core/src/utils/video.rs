@@ -1,6 +1,7 @@
 use anyhow::{bail, Result};
 use crunchyroll_rs::media::{Resolution, Stream, StreamData};
 use crunchyroll_rs::Locale;
+use log::warn;
 
 pub async fn stream_data_from_stream(
     stream: &Stream,
@@ -38,9 +39,33 @@ pub async fn stream_data_from_stream(
     let video_variant = match resolution.height {
         u64::MAX => Some(videos.into_iter().next().unwrap()),
         u64::MIN => Some(videos.into_iter().last().unwrap()),
-        _ => videos
-            .into_iter()
-            .find(|v| resolution.height == v.resolution().unwrap().height),
+        _ => {
+            if let Some(position) = videos
+                .iter()
+                .position(|v| resolution.height == v.resolution().unwrap().height)
+            {
+                Some(videos.remove(position))
+            } else {
+                // if the exact resolution isn't available, the closest resolution which is lower
+                // than the requested one is used. if there is none, the lowest available resolution
+                // is used. like with an exact match, the variant with the highest bandwidth is
+                // preferred if multiple have the same resolution
+                let closest = videos
+                    .iter()
+                    .filter(|v| v.resolution().unwrap().height < resolution.height)
+                    .max_by_key(|v| (v.resolution().unwrap().height, v.bandwidth))
+                    .or_else(|| videos.iter().min_by_key(|v| v.resolution().unwrap().height))
+                    .cloned();
+                if let Some(c) = &closest {
+                    warn!(
+                        "Resolution {} is not available, using {} instead",
+                        resolution,
+                        c.resolution().unwrap()
+                    )
+                }
+                closest
+            }
+        }
     };
     Ok(video_variant.map(|v| (v, audios.first().unwrap().clone(), contains_hardsub)))
 }